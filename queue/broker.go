@@ -0,0 +1,285 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "sync"
+
+// OverflowPolicy governs what a subscription does when its subscriber falls
+// behind and its buffered channel fills up.
+type OverflowPolicy int
+
+const (
+	// Block makes the publisher wait for the subscriber to catch up: once
+	// buffer Entries are already waiting to be delivered, deliver() blocks
+	// sending the next one into the subscriber's channel until it's read.
+	// That block is confined to this subscriber's own delivery goroutine
+	// (see deliver), so it never stalls the broker or any other
+	// subscriber; staging ahead of that point is bounded the same way
+	// DropOldest is, so a subscriber that falls further behind than
+	// buffer loses its oldest undelivered Entries rather than growing
+	// without bound.
+	Block OverflowPolicy = iota
+	// DropOldest discards the oldest undelivered Entry to make room for the
+	// newest one. Use for subscribers that only care about recent state.
+	DropOldest
+	// CoalesceByID keeps only the most recent Entry per object UID among
+	// those not yet delivered, collapsing redundant intermediate updates.
+	CoalesceByID
+)
+
+// CancelFunc unregisters a subscription. It is safe to call more than once.
+type CancelFunc func()
+
+// broker fans a stream of Entry notifications out to an arbitrary number of
+// subscribers without letting a slow subscriber backpressure the publisher
+// (and therefore the store, since HistoricalFIFO publishes while it no
+// longer holds f.lock) or any other subscriber.
+type broker struct {
+	mu   sync.Mutex
+	subs map[string]*subscription
+
+	in        chan Entry
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBroker() *broker {
+	b := &broker{
+		subs: map[string]*subscription{},
+		in:   make(chan Entry, 64),
+		done: make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Stop permanently halts b's dispatch loop and closes every active
+// subscriber's channel (as if each had been individually canceled). After
+// Stop, publish is a no-op; b must not be used again.
+func (b *broker) Stop() {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		subs := make([]*subscription, 0, len(b.subs))
+		for _, s := range b.subs {
+			subs = append(subs, s)
+		}
+		b.subs = map[string]*subscription{}
+		b.mu.Unlock()
+
+		for _, s := range subs {
+			s.close()
+		}
+		close(b.done)
+	})
+}
+
+// publish delivers e to every subscriber whose filter matches. It never
+// blocks on a subscriber; it only blocks (briefly) if the broker's own
+// internal buffer is momentarily full.
+func (b *broker) publish(e Entry) {
+	if e == nil {
+		return
+	}
+	select {
+	case b.in <- e:
+	case <-b.done:
+	}
+}
+
+func (b *broker) run() {
+	for {
+		select {
+		case e := <-b.in:
+			b.mu.Lock()
+			for _, s := range b.subs {
+				if e.Is(s.filter) {
+					// each subscriber gets its own copy so that one
+					// subscriber mutating (or just holding onto) an Entry
+					// can never affect another.
+					s.enqueue(e.Copy().(Entry))
+				}
+			}
+			b.mu.Unlock()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *broker) subscribe(name string, filter EventType, buffer int, policy OverflowPolicy) (<-chan Entry, CancelFunc) {
+	s := newSubscription(filter, buffer, policy)
+
+	b.mu.Lock()
+	b.subs[name] = s
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, name)
+			b.mu.Unlock()
+			s.close()
+		})
+	}
+	return s.out, cancel
+}
+
+// subscription owns one subscriber's channel plus a staging backlog, bounded
+// to buffer Entries, that decouples the broker's fan-out loop from however
+// slowly (or via whatever policy) this particular subscriber drains its
+// channel.
+type subscription struct {
+	filter EventType
+	policy OverflowPolicy
+	buffer int // bound on len(backlog) for Block/DropOldest; <1 is never constructed, see newSubscription
+	out    chan Entry
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	closed  bool
+	done    chan struct{}    // closed by close(), so deliver() can abandon a blocked send
+	backlog []Entry          // used by Block and DropOldest, bounded to buffer
+	order   []string         // used by CoalesceByID: arrival order of UIDs
+	latest  map[string]Entry // used by CoalesceByID: UID -> most recent Entry
+}
+
+func newSubscription(filter EventType, buffer int, policy OverflowPolicy) *subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	s := &subscription{
+		filter: filter,
+		policy: policy,
+		buffer: buffer,
+		out:    make(chan Entry, buffer),
+		done:   make(chan struct{}),
+	}
+	s.cond.L = &s.mu
+	if policy == CoalesceByID {
+		s.latest = map[string]Entry{}
+	}
+	go s.deliver()
+	return s
+}
+
+// enqueue is called from the broker's run loop and must never block: for
+// CoalesceByID at most one Entry per UID is ever pending, and for Block and
+// DropOldest alike, staging ahead of deliver() is capped at buffer Entries,
+// with the oldest one evicted to make room for e once it's full. Block's
+// actual "wait for the subscriber" behavior happens later, in deliver()'s
+// blocking send into s.out -- confined to this subscription's own delivery
+// goroutine -- not here.
+func (s *subscription) enqueue(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.policy == CoalesceByID {
+		id := e.Value().GetUID()
+		if _, exists := s.latest[id]; !exists {
+			s.order = append(s.order, id)
+		}
+		s.latest[id] = e
+	} else {
+		if len(s.backlog) >= s.buffer {
+			s.backlog = s.backlog[1:]
+		}
+		s.backlog = append(s.backlog, e)
+	}
+	s.cond.Broadcast()
+}
+
+func (s *subscription) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	close(s.done)
+}
+
+// deliver drains this subscription's backlog into its channel according to
+// its overflow policy, running in its own goroutine so that it can block
+// (under the Block policy) without affecting any other subscriber. Once
+// close() has been called, a send that would otherwise block forever (e.g.
+// the consumer broke out of its range loop and called its CancelFunc instead
+// of draining the rest of the buffer) is abandoned in favor of closing
+// s.out, so this goroutine -- and the CancelFunc's promise to close the
+// channel -- doesn't leak.
+func (s *subscription) deliver() {
+	for {
+		e, ok := s.next()
+		if !ok {
+			close(s.out)
+			return
+		}
+		switch s.policy {
+		case DropOldest:
+			select {
+			case s.out <- e:
+			default:
+				select {
+				case <-s.out:
+				default:
+				}
+				select {
+				case s.out <- e:
+				default:
+				}
+			}
+		default: // Block, CoalesceByID
+			select {
+			case s.out <- e:
+			case <-s.done:
+				close(s.out)
+				return
+			}
+		}
+	}
+}
+
+// next blocks until there's an Entry to deliver, or the subscription has
+// been closed and drained, in which case ok is false.
+func (s *subscription) next() (e Entry, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.pendingLocked() == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.pendingLocked() == 0 {
+		return nil, false
+	}
+	if s.policy == CoalesceByID {
+		id := s.order[0]
+		s.order = s.order[1:]
+		e = s.latest[id]
+		delete(s.latest, id)
+		return e, true
+	}
+	e = s.backlog[0]
+	s.backlog = s.backlog[1:]
+	return e, true
+}
+
+func (s *subscription) pendingLocked() int {
+	if s.policy == CoalesceByID {
+		return len(s.order)
+	}
+	return len(s.backlog)
+}
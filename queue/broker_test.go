@@ -0,0 +1,93 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSubscriptionBlockBoundsBacklog verifies that a Block subscriber's
+// staging backlog is capped at buffer entries (with the oldest evicted to
+// make room), rather than growing without bound while deliver() is stalled
+// on an undrained channel.
+func TestSubscriptionBlockBoundsBacklog(t *testing.T) {
+	s := newSubscription(ADD_EVENT, 2, Block)
+	defer s.close()
+
+	for i := 0; i < 100; i++ {
+		s.enqueue(&entry{value: &testObj{"x", i}, event: ADD_EVENT})
+	}
+
+	s.mu.Lock()
+	n := len(s.backlog)
+	s.mu.Unlock()
+	if n > 2 {
+		t.Fatalf("expected backlog bounded to buffer=2 entries, got %d", n)
+	}
+}
+
+// TestSubscriptionDropOldestDeliversNewest verifies that a DropOldest
+// subscriber's channel ends up holding the most recently enqueued Entry, not
+// whichever one happened to be delivered first.
+func TestSubscriptionDropOldestDeliversNewest(t *testing.T) {
+	s := newSubscription(ADD_EVENT, 1, DropOldest)
+	defer s.close()
+
+	for i := 0; i < 5; i++ {
+		s.enqueue(&entry{value: &testObj{"x", i}, event: ADD_EVENT})
+		time.Sleep(5 * time.Millisecond) // let deliver() drain/overwrite s.out
+	}
+
+	select {
+	case e := <-s.out:
+		if got := e.Value().(*testObj).val; got != 4 {
+			t.Fatalf("expected the newest value (4), got %d", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+}
+
+// TestSubscriptionCoalesceByIDKeepsLatestPerID verifies that enqueueing
+// several Entries for the same UID before they're delivered collapses down
+// to just the most recent one per UID, in first-seen order.
+func TestSubscriptionCoalesceByIDKeepsLatestPerID(t *testing.T) {
+	s := newSubscription(ADD_EVENT, 10, CoalesceByID)
+	defer s.close()
+
+	s.enqueue(&entry{value: &testObj{"a", 1}, event: ADD_EVENT})
+	s.enqueue(&entry{value: &testObj{"b", 1}, event: ADD_EVENT})
+	s.enqueue(&entry{value: &testObj{"a", 2}, event: ADD_EVENT})
+
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-s.out:
+			o := e.Value().(*testObj)
+			got[o.uid] = o.val
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for delivery")
+		}
+	}
+	if got["a"] != 2 || got["b"] != 1 {
+		t.Fatalf("expected latest value per id (a=2, b=1), got %+v", got)
+	}
+
+	select {
+	case e := <-s.out:
+		t.Fatalf("expected exactly one delivery per id, got an extra one: %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// evolution of: https://github.com/GoogleCloudPlatform/kubernetes/blob/release-0.6/pkg/client/cache/delta_fifo.go
+package queue
+
+import (
+	"sync"
+)
+
+// Delta is a notification of a change to a single object: what happened
+// (Type) and the object's state immediately after that happened (except for
+// a DELETE_EVENT, where Object is the last known state before removal).
+type Delta struct {
+	Type   EventType
+	Object UniqueCopyable
+}
+
+// Deltas is the ordered history of changes to a single object, oldest first.
+// Unlike HistoricalFIFO's Entry, which only ever reflects the most recent
+// change, a Deltas may contain several: e.g. [Added, Updated, Updated]. This
+// lets a consumer observe every transition an object went through rather
+// than just its latest state.
+type Deltas []Delta
+
+// Newest returns the most recently appended Delta, or the zero Delta if d is
+// empty.
+func (d Deltas) Newest() Delta {
+	if len(d) == 0 {
+		return Delta{}
+	}
+	return d[len(d)-1]
+}
+
+// DeltaFIFO is a FIFO queue with HistoricalFIFO's add/update/delete/replace
+// semantics, except that it never collapses a key's history down to a
+// single Entry: Pop returns the full Deltas for a key, so a consumer can
+// replay every intermediate Add/Update/Delete. Deltas are compacted only
+// when consecutive entries are redundant; see dedupDeltas.
+type DeltaFIFO struct {
+	lock sync.RWMutex
+	cond sync.Cond
+
+	// items maps a key to its accumulated, not-yet-popped Deltas. We depend
+	// on the property that every key in queue has a (non-empty) entry here.
+	items map[string]Deltas
+	queue []string
+}
+
+// NewDeltaFIFO returns an initialized DeltaFIFO.
+func NewDeltaFIFO() *DeltaFIFO {
+	f := &DeltaFIFO{
+		items: map[string]Deltas{},
+		queue: []string{},
+	}
+	f.cond.L = &f.lock
+	return f
+}
+
+// Add records an ADD_EVENT delta for id, whether or not id already has
+// Deltas; if it does and the object's identity changed underneath us,
+// queueActionLocked records a hidden Delete ahead of the Add.
+func (f *DeltaFIFO) Add(id string, v interface{}) {
+	obj := checkType(v)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.queueActionLocked(ADD_EVENT, id, obj)
+	f.cond.Broadcast()
+}
+
+// Update is the same as Add, except that the delta it records is an
+// UPDATE_EVENT (or an ADD_EVENT after a hidden Delete).
+func (f *DeltaFIFO) Update(id string, v interface{}) {
+	obj := checkType(v)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.queueActionLocked(UPDATE_EVENT, id, obj)
+	f.cond.Broadcast()
+}
+
+// Delete records a DELETE_EVENT delta for id if id has Deltas that haven't
+// been popped yet. An id that was already popped (or never added) is not
+// tracked by f, and Delete does nothing for it. If the only delta recorded
+// so far is an ADD_EVENT, the add is canceled outright: no consumer ever
+// observed the object, so there's nothing to tell them about its removal
+// either.
+func (f *DeltaFIFO) Delete(id string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	deltas, exists := f.items[id]
+	if !exists {
+		return
+	}
+	newest := deltas.Newest()
+	if newest.Type&DELETE_EVENT != 0 {
+		return
+	}
+	if len(deltas) == 1 && newest.Type == ADD_EVENT {
+		delete(f.items, id)
+		f.removeFromQueueLocked(id)
+		return
+	}
+	f.items[id] = dedupDeltas(append(deltas, Delta{DELETE_EVENT, newest.Object}))
+	f.cond.Broadcast()
+}
+
+// List returns the most recent, non-deleted state of every item.
+func (f *DeltaFIFO) List() []interface{} {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	list := make([]interface{}, 0, len(f.items))
+	for _, deltas := range f.items {
+		if newest := deltas.Newest(); newest.Type&DELETE_EVENT == 0 {
+			list = append(list, newest.Object.Copy())
+		}
+	}
+	return list
+}
+
+// Get returns the most recent, non-deleted state of id, or sets exists=false.
+func (f *DeltaFIFO) Get(id string) (interface{}, bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	deltas, ok := f.items[id]
+	if !ok {
+		return nil, false
+	}
+	newest := deltas.Newest()
+	if newest.Type&DELETE_EVENT != 0 {
+		return nil, false
+	}
+	return newest.Object.Copy(), true
+}
+
+// Pop waits until a key has pending Deltas and returns them, removing the
+// key from the queue and the store. If you don't successfully process the
+// Deltas, add the key back with Add()/Update() as appropriate.
+func (f *DeltaFIFO) Pop() Deltas {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for {
+		for len(f.queue) == 0 {
+			f.cond.Wait()
+		}
+		id := f.queue[0]
+		f.queue = f.queue[1:]
+		deltas, ok := f.items[id]
+		if !ok {
+			// Item may have been deleted (and canceled) subsequently.
+			continue
+		}
+		delete(f.items, id)
+		return deltas
+	}
+}
+
+// Replace reconciles f with the given map without discarding any pending
+// Deltas. Keys that still have unpopped Deltas but are missing from idToObj
+// get a synthesized DELETE_EVENT delta appended; keys present in idToObj
+// get a synthesized SYNC_EVENT delta appended. Keys that were already
+// popped are not tracked by f, so no DELETE_EVENT is synthesized for them.
+func (f *DeltaFIFO) Replace(idToObj map[string]interface{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for id, deltas := range f.items {
+		if _, exists := idToObj[id]; exists {
+			continue
+		}
+		newest := deltas.Newest()
+		if newest.Type&DELETE_EVENT != 0 {
+			continue
+		}
+		f.items[id] = append(deltas, Delta{DELETE_EVENT, newest.Object})
+		if !inQueue(f.queue, id) {
+			f.queue = append(f.queue, id)
+		}
+	}
+	for id, v := range idToObj {
+		obj := checkType(v).Copy().(UniqueCopyable)
+		deltas, exists := f.items[id]
+		f.items[id] = append(deltas, Delta{SYNC_EVENT, obj})
+		if !exists {
+			f.queue = append(f.queue, id)
+		}
+	}
+	f.cond.Broadcast()
+}
+
+// queueActionLocked appends a delta of type t for id, detecting the "hidden
+// delete" case where an object's UID changed between two Adds/Updates
+// without an explicit Delete ever being observed (the same case
+// HistoricalFIFO.merge() detects via UID comparison) and expanding it into
+// an explicit Delete followed by an Add. Assumes the caller holds f.lock.
+func (f *DeltaFIFO) queueActionLocked(t EventType, id string, obj UniqueCopyable) {
+	deltas, exists := f.items[id]
+	if !exists {
+		f.items[id] = Deltas{{t, obj.Copy().(UniqueCopyable)}}
+		f.queue = append(f.queue, id)
+		return
+	}
+
+	newest := deltas.Newest()
+	if newest.Type&DELETE_EVENT == 0 && newest.Object.GetUID() != obj.GetUID() {
+		deltas = append(deltas, Delta{DELETE_EVENT, newest.Object})
+		t = ADD_EVENT
+	}
+	deltas = dedupDeltas(append(deltas, Delta{t, obj.Copy().(UniqueCopyable)}))
+	f.items[id] = deltas
+	if !inQueue(f.queue, id) {
+		f.queue = append(f.queue, id)
+	}
+}
+
+// removeFromQueueLocked splices id out of the queue. Assumes the caller
+// holds f.lock.
+func (f *DeltaFIFO) removeFromQueueLocked(id string) {
+	for i, qid := range f.queue {
+		if qid == id {
+			f.queue = append(f.queue[:i], f.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+func inQueue(queue []string, id string) bool {
+	for _, qid := range queue {
+		if qid == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupDeltas compacts the trailing two Deltas of d when they're redundant:
+// two consecutive Updates collapse to the latest Update. Other sequences are
+// left alone so a consumer can still observe every transition.
+func dedupDeltas(d Deltas) Deltas {
+	n := len(d)
+	if n < 2 {
+		return d
+	}
+	a, b := d[n-2], d[n-1]
+	if a.Type == UPDATE_EVENT && b.Type == UPDATE_EVENT {
+		return append(d[:n-2], b)
+	}
+	return d
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "testing"
+
+// TestDeltaFIFODedupsConsecutiveUpdates verifies dedupDeltas collapses two
+// consecutive Updates down to the latest one, while still keeping the
+// earlier Add.
+func TestDeltaFIFODedupsConsecutiveUpdates(t *testing.T) {
+	f := NewDeltaFIFO()
+	f.Add("a", &testObj{"a", 1})
+	f.Update("a", &testObj{"a", 2})
+	f.Update("a", &testObj{"a", 3})
+
+	d := f.Pop()
+	if len(d) != 2 || d[0].Type != ADD_EVENT || d[1].Type != UPDATE_EVENT {
+		t.Fatalf("expected [Add, Update(latest)], got %+v", d)
+	}
+	if v := d[1].Object.(*testObj).val; v != 3 {
+		t.Fatalf("expected the collapsed Update to carry the latest value (3), got %d", v)
+	}
+}
+
+// TestDeltaFIFOHiddenDeleteOnUIDChange verifies that queueActionLocked
+// detects an object's UID changing underneath the same id -- without an
+// explicit Delete ever being observed -- and expands it into a Delete
+// followed by an Add, same as HistoricalFIFO.merge does.
+func TestDeltaFIFOHiddenDeleteOnUIDChange(t *testing.T) {
+	f := NewDeltaFIFO()
+	f.Add("a", &testObj{"a-v1", 1})
+	f.Add("a", &testObj{"a-v2", 2})
+
+	d := f.Pop()
+	if len(d) != 3 || d[0].Type != ADD_EVENT || d[1].Type != DELETE_EVENT || d[2].Type != ADD_EVENT {
+		t.Fatalf("expected [Add, Delete(old uid), Add(new uid)], got %+v", d)
+	}
+	if got := d[1].Object.(*testObj).uid; got != "a-v1" {
+		t.Fatalf("expected the hidden Delete to carry the old object (a-v1), got %q", got)
+	}
+	if got := d[2].Object.(*testObj).uid; got != "a-v2" {
+		t.Fatalf("expected the trailing Add to carry the new object (a-v2), got %q", got)
+	}
+}
+
+// TestDeltaFIFODeleteAfterAddCancels verifies that deleting an id whose only
+// recorded delta is an unpopped Add cancels the add outright, since no
+// consumer ever observed the object.
+func TestDeltaFIFODeleteAfterAddCancels(t *testing.T) {
+	f := NewDeltaFIFO()
+	f.Add("a", &testObj{"a", 1})
+	f.Delete("a")
+
+	if _, exists := f.Get("a"); exists {
+		t.Fatal("expected the canceled add+delete to vanish from the store")
+	}
+	if list := f.List(); len(list) != 0 {
+		t.Fatalf("expected an empty List after the canceled add+delete, got %+v", list)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "time"
+
+// expHeapItem is a pending physical reap: id's deletedEntry is due for
+// removal at expiration.
+type expHeapItem struct {
+	id         string
+	expiration time.Time
+}
+
+// expHeap is a container/heap min-heap of expHeapItems ordered by
+// expiration. It lets gc() find the next deletedEntry due for reaping in
+// O(log N), instead of the O(N) scan over every item the old fixed-interval
+// gc() did. Entries can go stale (the id was re-Added, or re-Deleted with a
+// fresher deadline) without being removed from the heap; gc() discards
+// those when it pops them rather than paying to find and remove them
+// eagerly.
+type expHeap []expHeapItem
+
+func (h expHeap) Len() int { return len(h) }
+
+func (h expHeap) Less(i, j int) bool { return h[i].expiration.Before(h[j].expiration) }
+
+func (h expHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *expHeap) Push(x interface{}) {
+	*h = append(*h, x.(expHeapItem))
+}
+
+func (h *expHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
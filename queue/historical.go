@@ -15,6 +15,7 @@ limitations under the License.
 package queue
 
 import (
+	"container/heap"
 	"fmt"
 	"reflect"
 	"sync"
@@ -30,6 +31,14 @@ const (
 	UPDATE_EVENT
 	DELETE_EVENT
 	POP_EVENT
+	// SYNC_EVENT marks an object re-affirmed by a Replace() (relist), as
+	// opposed to one that actually changed; see DeltaFIFO.Replace.
+	SYNC_EVENT
+	// EXPIRED_EVENT marks an item actually reaped by gc() once its linger
+	// TTL passed, as opposed to DELETE_EVENT, which only marks an item
+	// logically deleted while it still lingers in the store. Subscribe to
+	// it via SubscribeExpirations to react to true removal.
+	EXPIRED_EVENT
 )
 
 type Entry interface {
@@ -42,6 +51,11 @@ type Entry interface {
 type entry struct {
 	value UniqueCopyable
 	event EventType
+	// expiresAt is the deadline set by AddWithTTL, after which the entry is
+	// treated as logically deleted even though no Delete()/Replace() ever
+	// touched it. The zero Time means "never expires" -- the behavior
+	// every entry had before AddWithTTL existed, and still has via Add().
+	expiresAt time.Time
 }
 
 type deletedEntry struct {
@@ -71,7 +85,7 @@ func (e *entry) Copy() Copyable {
 	if e == nil {
 		return nil
 	}
-	return &entry{e.value.Copy().(UniqueCopyable), e.event}
+	return &entry{e.value.Copy().(UniqueCopyable), e.event, e.expiresAt}
 }
 
 func (e *entry) Is(types EventType) bool {
@@ -85,26 +99,64 @@ func (e *deletedEntry) Copy() Copyable {
 	return &deletedEntry{e.entry.Copy().(*entry), e.expiration}
 }
 
-// deliver a message
-type pigeon func(msg Entry)
-
-func dead(msg Entry) {
-	// intentionally blank
-}
-
 // HistoricalFIFO receives adds and updates from a Reflector, and puts them in a queue for
 // FIFO order processing. If multiple adds/updates of a single item happen while
 // an item is in the queue before it has been processed, it will only be
 // processed once, and when it is processed, the most recent version will be
 // processed. This can't be done with a channel.
 type HistoricalFIFO struct {
-	lock      sync.RWMutex
-	cond      sync.Cond
-	items     map[string]Entry // We depend on the property that items in the queue are in the set.
-	queue     []string
-	carrier   pigeon // may be dead, but never nil
-	gcc       int
-	lingerTTL time.Duration
+	lock        sync.RWMutex
+	cond        sync.Cond
+	items       map[string]Entry // We depend on the property that items in the queue are in the set.
+	queue       *idQueue
+	events      *broker // fans out notifications to Subscribe()rs; never nil
+	indexers    Indexers
+	indices     Indices
+	expirations expHeap // min-heap of pending deletedEntry reaps, by expiration
+	lingerTTL   time.Duration
+	stop        chan struct{} // closed by Close() to stop the background sweeper
+	closeOnce   sync.Once
+}
+
+// Close permanently stops f's background expiration sweeper and notification
+// broker, closing every subscriber's channel as if each had called its
+// CancelFunc. Forwarding to the legacy channel passed to NewFIFO, if any,
+// stops, but that channel itself is not closed. f itself
+// remains safe to call afterward, but will never again deliver notifications
+// or reap expired entries in the background. Safe to call more than once.
+func (f *HistoricalFIFO) Close() {
+	f.closeOnce.Do(func() {
+		close(f.stop)
+		f.events.Stop()
+	})
+}
+
+// Subscribe registers a new subscriber named name that receives a copy of
+// every Entry whose event type matches filter (e.g. ADD_EVENT|UPDATE_EVENT).
+// Delivery happens on a goroutine dedicated to this subscriber, after f.lock
+// has been released, so a slow subscriber can never back-pressure Add,
+// Update, Delete or Pop, nor any other subscriber. The returned CancelFunc
+// unregisters the subscriber and closes its channel; callers should always
+// call it once they're done, typically via defer.
+//
+// Overflow (the subscriber falling behind with buffer pending Entries) is
+// handled with the Block policy; use SubscribeWithPolicy for drop-oldest or
+// coalesce-by-id semantics instead.
+func (f *HistoricalFIFO) Subscribe(name string, filter EventType, buffer int) (<-chan Entry, CancelFunc) {
+	return f.SubscribeWithPolicy(name, filter, buffer, Block)
+}
+
+// SubscribeWithPolicy is Subscribe with an explicit OverflowPolicy.
+func (f *HistoricalFIFO) SubscribeWithPolicy(name string, filter EventType, buffer int, policy OverflowPolicy) (<-chan Entry, CancelFunc) {
+	return f.events.subscribe(name, filter, buffer, policy)
+}
+
+// SubscribeExpirations is Subscribe restricted to EXPIRED_EVENT: true
+// removals performed by gc() once an item's linger TTL has passed, as
+// opposed to DELETE_EVENT, which only marks an item logically deleted while
+// it lingers in the store waiting to be reaped.
+func (f *HistoricalFIFO) SubscribeExpirations(name string, buffer int) (<-chan Entry, CancelFunc) {
+	return f.Subscribe(name, EXPIRED_EVENT, buffer)
 }
 
 // panics if obj doesn't implement UniqueCopyable; otherwise returns the same, typecast object
@@ -123,7 +175,7 @@ func (f *HistoricalFIFO) Add(id string, v interface{}) {
 	notifications := []Entry(nil)
 	defer func() {
 		for _, e := range notifications {
-			f.carrier(e)
+			f.events.publish(e)
 		}
 	}()
 
@@ -131,13 +183,13 @@ func (f *HistoricalFIFO) Add(id string, v interface{}) {
 	defer f.lock.Unlock()
 
 	if entry, exists := f.items[id]; !exists {
-		f.queue = append(f.queue, id)
+		f.queue.Push(id)
 	} else {
 		if entry.Is(DELETE_EVENT | POP_EVENT) {
-			f.queue = append(f.queue, id)
+			f.queue.Push(id)
 		}
 	}
-	notifications = f.merge(id, obj)
+	notifications = f.merge(id, obj, 0)
 	f.cond.Broadcast()
 }
 
@@ -146,6 +198,36 @@ func (f *HistoricalFIFO) Update(id string, obj interface{}) {
 	f.Add(id, obj)
 }
 
+// AddWithTTL is Add, except that the item is considered logically deleted
+// once ttl elapses from now, whether or not anyone ever calls Delete() or
+// Replace() for it. A synthetic DELETE_EVENT is produced for it -- exactly
+// as if Delete(id) had been called -- the next time that's noticed, either
+// by Get, List or Pop's lazy check, or by the background sweeper started by
+// NewFIFO. Use Add, which this embeds as the ttl <= 0 case, for the default
+// of no TTL (never expires).
+func (f *HistoricalFIFO) AddWithTTL(id string, v interface{}, ttl time.Duration) {
+	obj := checkType(v)
+	notifications := []Entry(nil)
+	defer func() {
+		for _, e := range notifications {
+			f.events.publish(e)
+		}
+	}()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if entry, exists := f.items[id]; !exists {
+		f.queue.Push(id)
+	} else {
+		if entry.Is(DELETE_EVENT | POP_EVENT) {
+			f.queue.Push(id)
+		}
+	}
+	notifications = f.merge(id, obj, ttl)
+	f.cond.Broadcast()
+}
+
 // Add the item to the store, but only if there exists a prior entry for
 // for the object in the store whose event type matches that given, and then
 // only enqueued if it doesn't already exist in the set.
@@ -154,7 +236,7 @@ func (f *HistoricalFIFO) Readd(id string, v interface{}, t EventType) {
 	notifications := []Entry(nil)
 	defer func() {
 		for _, e := range notifications {
-			f.carrier(e)
+			f.events.publish(e)
 		}
 	}()
 
@@ -165,10 +247,10 @@ func (f *HistoricalFIFO) Readd(id string, v interface{}, t EventType) {
 		if !entry.Is(t) {
 			return
 		} else if entry.Is(DELETE_EVENT | POP_EVENT) {
-			f.queue = append(f.queue, id)
+			f.queue.Push(id)
 		}
 	}
-	notifications = f.merge(id, obj)
+	notifications = f.merge(id, obj, 0)
 	f.cond.Broadcast()
 }
 
@@ -176,9 +258,11 @@ func (f *HistoricalFIFO) Readd(id string, v interface{}, t EventType) {
 // this implementation assumes the consumer only cares about the objects,
 // not the order in which they were created/added.
 func (f *HistoricalFIFO) Delete(id string) {
-	deleteEvent := (Entry)(nil)
+	notifications := []Entry(nil)
 	defer func() {
-		f.carrier(deleteEvent)
+		for _, e := range notifications {
+			f.events.publish(e)
+		}
 	}()
 
 	f.lock.Lock()
@@ -187,23 +271,38 @@ func (f *HistoricalFIFO) Delete(id string) {
 	if exists && !item.Is(DELETE_EVENT) {
 		e := item.(*entry)
 		e.event = DELETE_EVENT
-		deleteEvent = &deletedEntry{e, time.Now().Add(f.lingerTTL)}
+		deleteEvent := &deletedEntry{e, time.Now().Add(f.lingerTTL)}
 		f.items[id] = deleteEvent
+		f.updateIndicesLocked(e.Value(), nil, id)
+		f.scheduleExpirationLocked(id, deleteEvent.expiration)
+		notifications = append(notifications, deleteEvent)
 	}
+	notifications = append(notifications, f.gc()...)
 }
 
 // List returns a list of all the items.
 func (f *HistoricalFIFO) List() []interface{} {
-	f.lock.RLock()
-	defer f.lock.RUnlock()
+	expired := []Entry(nil)
+	defer func() {
+		for _, e := range expired {
+			f.events.publish(e)
+		}
+	}()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
 
 	// TODO(jdef): slightly overallocates b/c of deleted items
-	list := make([]interface{}, 0, len(f.queue))
+	list := make([]interface{}, 0, f.queue.Len())
 
-	for _, entry := range f.items {
+	for id, entry := range f.items {
 		if entry.Is(DELETE_EVENT | POP_EVENT) {
 			continue
 		}
+		if e := f.expireLocked(id, entry); e != nil {
+			expired = append(expired, e)
+			continue
+		}
 		list = append(list, entry.Value().Copy())
 	}
 	return list
@@ -211,29 +310,52 @@ func (f *HistoricalFIFO) List() []interface{} {
 
 // ContainedIDs returns a util.StringSet containing all IDs of the stored items.
 // This is a snapshot of a moment in time, and one should keep in mind that
-// other go routines can add or remove items after you call this.
-func (c *HistoricalFIFO) ContainedIDs() util.StringSet {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+// other go routines can add or remove items after you call this. Like
+// List(), an item whose AddWithTTL deadline has passed is treated as absent
+// even if nothing has reaped it yet.
+func (f *HistoricalFIFO) ContainedIDs() util.StringSet {
+	expired := []Entry(nil)
+	defer func() {
+		for _, e := range expired {
+			f.events.publish(e)
+		}
+	}()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	set := util.StringSet{}
-	for id, entry := range c.items {
+	for id, entry := range f.items {
 		if entry.Is(DELETE_EVENT | POP_EVENT) {
 			continue
 		}
+		if e := f.expireLocked(id, entry); e != nil {
+			expired = append(expired, e)
+			continue
+		}
 		set.Insert(id)
 	}
 	return set
 }
 
-// Get returns the requested item, or sets exists=false.
+// Get returns the requested item, or sets exists=false. An item whose
+// AddWithTTL deadline has passed is treated as not found, same as one that
+// was explicitly Delete()d.
 func (f *HistoricalFIFO) Get(id string) (interface{}, bool) {
-	f.lock.RLock()
-	defer f.lock.RUnlock()
+	expiredEvent := (Entry)(nil)
+	defer func() {
+		f.events.publish(expiredEvent)
+	}()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	entry, exists := f.items[id]
-	if exists && !entry.Is(DELETE_EVENT|POP_EVENT) {
-		return entry.Value().Copy(), true
+	if !exists || entry.Is(DELETE_EVENT|POP_EVENT) {
+		return nil, false
+	}
+	if expiredEvent = f.expireLocked(id, entry); expiredEvent != nil {
+		return nil, false
 	}
-	return nil, false
+	return entry.Value().Copy(), true
 }
 
 // Pop waits until an item is ready and returns it. If multiple items are
@@ -242,25 +364,33 @@ func (f *HistoricalFIFO) Get(id string) (interface{}, bool) {
 // so if you don't succesfully process it, you need to add it back with Add().
 func (f *HistoricalFIFO) Pop() interface{} {
 	popEvent := (Entry)(nil)
+	expired := []Entry(nil)
 	defer func() {
-		f.carrier(popEvent)
+		for _, e := range expired {
+			f.events.publish(e)
+		}
+		f.events.publish(popEvent)
 	}()
 
 	f.lock.Lock()
 	defer f.lock.Unlock()
 	for {
-		for len(f.queue) == 0 {
+		for f.queue.Len() == 0 {
 			f.cond.Wait()
 		}
-		id := f.queue[0]
-		f.queue = f.queue[1:]
+		id, _ := f.queue.Pop()
 		item, ok := f.items[id]
 		if !ok || item.Is(DELETE_EVENT|POP_EVENT) {
 			// Item may have been deleted subsequently.
 			continue
 		}
+		if e := f.expireLocked(id, item); e != nil {
+			// Item's AddWithTTL deadline passed before it got here.
+			expired = append(expired, e)
+			continue
+		}
 		value := item.Value()
-		popEvent = &entry{value, POP_EVENT}
+		popEvent = &entry{value: value, event: POP_EVENT}
 		f.items[id] = popEvent
 		return value.Copy()
 	}
@@ -274,14 +404,14 @@ func (f *HistoricalFIFO) Replace(idToObj map[string]interface{}) {
 	notifications := make([]Entry, 0, len(idToObj))
 	defer func() {
 		for _, e := range notifications {
-			f.carrier(e)
+			f.events.publish(e)
 		}
 	}()
 
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
-	f.queue = f.queue[:0]
+	f.queue.Reset()
 	now := time.Now()
 	for id, v := range f.items {
 		if _, exists := idToObj[id]; !exists && !v.Is(DELETE_EVENT) {
@@ -292,51 +422,106 @@ func (f *HistoricalFIFO) Replace(idToObj map[string]interface{}) {
 			e := &deletedEntry{ent, now.Add(f.lingerTTL)}
 			f.items[id] = e
 			notifications = append(notifications, e)
+			f.updateIndicesLocked(ent.Value(), nil, id)
+			f.scheduleExpirationLocked(id, e.expiration)
 		}
 	}
 	for id, v := range idToObj {
 		obj := checkType(v)
-		f.queue = append(f.queue, id)
-		n := f.merge(id, obj)
+		f.queue.Push(id)
+		n := f.merge(id, obj, 0)
 		notifications = append(notifications, n...)
 	}
-	if len(f.queue) > 0 {
+	if f.queue.Len() > 0 {
 		f.cond.Broadcast()
 	}
 }
 
-// garbage collect DELETEd items whose TTL has expired; the IDs of such items are removed
-// from the queue. This impl assumes that caller has acquired state lock.
-func (f *HistoricalFIFO) gc() {
+// gcBatchSize bounds how many due deletedEntrys gc() reaps per call, so the
+// cost of garbage collection is amortized across many mutations (and across
+// background sweeps; see startExpirationSweep) instead of paying for an
+// O(N) scan over the whole store all at once, as the old every-256th-merge
+// gc() did.
+const gcBatchSize = 32 //TODO(jdef): extract constant
+
+// gc reaps up to gcBatchSize deletedEntrys whose TTL has actually come due,
+// using f.expirations -- a min-heap ordered by deletedEntry.expiration,
+// pushed to by Delete(), Replace() and expireLocked() -- to find them in
+// O(log N) each rather than scanning every item in the store. A heap entry
+// can go stale (its id was re-Added, or re-Deleted with a fresher deadline,
+// since the entry was pushed); those are discarded without being reaped.
+// Returns an EXPIRED_EVENT notification per item actually reaped, so
+// subscribers registered via SubscribeExpirations can react to the true
+// removal, rather than only the earlier logical DELETE_EVENT. Assumes the
+// caller holds f.lock.
+func (f *HistoricalFIFO) gc() (expired []Entry) {
 	now := time.Now()
-	deleted := make(map[string]struct{})
-	for id, v := range f.items {
-		if v.Is(DELETE_EVENT) {
-			ent := v.(*deletedEntry)
-			if ent.expiration.Before(now) {
-				delete(f.items, id)
-				deleted[id] = struct{}{}
-			}
+	for i := 0; i < gcBatchSize && len(f.expirations) > 0; i++ {
+		top := f.expirations[0]
+		if top.expiration.After(now) {
+			break
 		}
-	}
-	// remove deleted items from the queue, will likely (slightly) overallocate here
-	queue := make([]string, 0, len(f.queue))
-	for _, id := range f.queue {
-		if _, exists := deleted[id]; !exists {
-			queue = append(queue, id)
+		heap.Pop(&f.expirations)
+
+		v, exists := f.items[top.id]
+		if !exists {
+			continue
+		}
+		ent, ok := v.(*deletedEntry)
+		if !ok || !ent.expiration.Equal(top.expiration) {
+			// stale: id was re-Added, or re-Deleted/expired again with a
+			// newer deadline that pushed its own, later heap entry.
+			continue
 		}
+		delete(f.items, top.id)
+		f.queue.Remove(top.id)
+		expired = append(expired, &entry{value: ent.Value(), event: EXPIRED_EVENT})
+		f.updateIndicesLocked(ent.Value(), nil, top.id)
 	}
-	f.queue = queue
+	return
+}
+
+// scheduleExpirationLocked records that id's current deletedEntry is due
+// for physical reaping at exp, so gc() can find it via f.expirations
+// instead of scanning every item. Assumes the caller holds f.lock.
+func (f *HistoricalFIFO) scheduleExpirationLocked(id string, exp time.Time) {
+	heap.Push(&f.expirations, expHeapItem{id, exp})
+}
+
+// expireLocked checks whether item, known to be live (not already
+// DELETE_EVENT/POP_EVENT), has passed the deadline set via AddWithTTL and,
+// if so, transitions it into a DELETE_EVENT exactly as Delete() would,
+// returning the resulting notification. Returns nil if item hasn't expired
+// -- including every item added via Add(), whose zero expiresAt never does.
+// Assumes the caller holds f.lock.
+func (f *HistoricalFIFO) expireLocked(id string, item Entry) Entry {
+	e, ok := item.(*entry)
+	if !ok || e.expiresAt.IsZero() || time.Now().Before(e.expiresAt) {
+		return nil
+	}
+	e.event = DELETE_EVENT
+	deleteEvent := &deletedEntry{e, time.Now().Add(f.lingerTTL)}
+	f.items[id] = deleteEvent
+	f.updateIndicesLocked(e.Value(), nil, id)
+	f.scheduleExpirationLocked(id, deleteEvent.expiration)
+	return deleteEvent
 }
 
-// Assumes that the caller has acquired the state lock.
-func (f *HistoricalFIFO) merge(id string, obj UniqueCopyable) (notifications []Entry) {
+// Assumes that the caller has acquired the state lock. ttl <= 0 means the
+// resulting entry never expires on its own, matching the behavior Add() has
+// always had; ttl > 0 is AddWithTTL's per-entry deadline.
+func (f *HistoricalFIFO) merge(id string, obj UniqueCopyable, ttl time.Duration) (notifications []Entry) {
 	item, exists := f.items[id]
 	now := time.Now()
+	expiresAt := time.Time{}
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
 	if !exists {
-		e := &entry{obj.Copy().(UniqueCopyable), ADD_EVENT}
+		e := &entry{obj.Copy().(UniqueCopyable), ADD_EVENT, expiresAt}
 		f.items[id] = e
 		notifications = append(notifications, e)
+		f.updateIndicesLocked(nil, e.Value(), id)
 	} else {
 		if !item.Is(DELETE_EVENT) && item.Value().GetUID() != obj.GetUID() {
 			// hidden DELETE!
@@ -344,46 +529,129 @@ func (f *HistoricalFIFO) merge(id string, obj UniqueCopyable) (notifications []E
 			// (2) append an ADD
 			// .. and notify listeners in that order
 			ent := item.(*entry)
+			oldValue := ent.Value()
 			ent.event = DELETE_EVENT
 			e1 := &deletedEntry{ent, now.Add(f.lingerTTL)}
-			e2 := &entry{obj.Copy().(UniqueCopyable), ADD_EVENT}
+			e2 := &entry{obj.Copy().(UniqueCopyable), ADD_EVENT, expiresAt}
 			f.items[id] = e2
 			notifications = append(notifications, e1, e2)
+			f.updateIndicesLocked(oldValue, e2.Value(), id)
+			f.scheduleExpirationLocked(id, e1.expiration)
 		} else if !reflect.DeepEqual(obj, item.Value()) {
 			//TODO(jdef): it would be nice if we could rely on resource versions
 			//instead of doing a DeepEqual. Maybe someday we'll be able to.
-			e := &entry{obj.Copy().(UniqueCopyable), UPDATE_EVENT}
+			oldValue := item.Value()
+			e := &entry{obj.Copy().(UniqueCopyable), UPDATE_EVENT, expiresAt}
 			f.items[id] = e
 			notifications = append(notifications, e)
+			f.updateIndicesLocked(oldValue, e.Value(), id)
 		}
 	}
-	// check for garbage collection
-	f.gcc++
-	if f.gcc%256 == 0 { //TODO(jdef): extract constant
-		f.gcc = 0
-		f.gc()
-	}
+	// gc is now O(gcBatchSize) instead of an O(N) full scan, so it's cheap
+	// enough to amortize across every merge rather than every 256th one.
+	notifications = append(notifications, f.gc()...)
 	return
 }
 
-// NewFIFO returns a Store which can be used to queue up items to
-// process. If a non-nil Mux is provided, then modifications to the
-// the FIFO are delivered on a channel specific to this fifo.
+// NewFIFO returns a Store which can be used to queue up items to process.
+// If ch is non-nil, it's subscribed to events of every EventType
+// (equivalent to calling Subscribe with a filter matching all EventTypes and
+// a buffer of 1, then forwarding the result onto ch). Under that Block
+// subscription a reader of ch that falls more than a couple of events
+// behind loses the oldest undelivered ones, rather than stalling f as the
+// old single-channel carrier did. Prefer Subscribe directly for new code,
+// since it allows registering more than one listener.
 func NewFIFO(ch chan<- Entry) *HistoricalFIFO {
-	carrier := dead
-	if ch != nil {
-		carrier = func(msg Entry) {
-			if msg != nil {
-				ch <- msg.Copy().(Entry)
-			}
-		}
-	}
 	f := &HistoricalFIFO{
 		items:     map[string]Entry{},
-		queue:     []string{},
-		carrier:   carrier,
+		queue:     newIDQueue(),
+		events:    newBroker(),
+		indexers:  Indexers{},
+		indices:   Indices{},
 		lingerTTL: 5 * time.Minute, // TODO(jdef): extract constant
+		stop:      make(chan struct{}),
 	}
 	f.cond.L = &f.lock
+	if ch != nil {
+		all := ADD_EVENT | UPDATE_EVENT | DELETE_EVENT | POP_EVENT | SYNC_EVENT | EXPIRED_EVENT
+		sub, _ := f.Subscribe("legacy", all, 1)
+		go func() {
+			for e := range sub {
+				ch <- e
+			}
+		}()
+	}
+	f.startExpirationSweep(expirationSweepInterval)
 	return f
 }
+
+// expirationSweepInterval is how often the background goroutine started by
+// NewFIFO checks for items whose AddWithTTL deadline has passed without
+// anyone having noticed via Get/List/Pop.
+const expirationSweepInterval = 1 * time.Second //TODO(jdef): extract constant
+
+// startExpirationSweep runs sweep until f.stop is closed by Close(), so that
+// entries added via AddWithTTL still expire, and their deletedEntrys still
+// get physically reaped, even if nothing ever calls Get, List or Pop for
+// them. Rather than waking on a fixed tick, it sleeps until nextWake reports
+// the next f.expirations deadline is actually due, falling back to interval
+// as a polling upper bound for live AddWithTTL entries, which aren't tracked
+// by f.expirations until they expire and become a deletedEntry.
+func (f *HistoricalFIFO) startExpirationSweep(interval time.Duration) {
+	go func() {
+		timer := time.NewTimer(f.nextWake(interval))
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				for _, e := range f.sweep() {
+					f.events.publish(e)
+				}
+				timer.Reset(f.nextWake(interval))
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+}
+
+// nextWake returns how long the background sweeper should sleep before its
+// next run: the time until f.expirations' earliest deadline if that's sooner
+// than fallback, or fallback otherwise (e.g. when f.expirations is empty).
+// If that deadline is already due it returns one millisecond, never a
+// non-positive duration, so the caller's timer always makes progress.
+func (f *HistoricalFIFO) nextWake(fallback time.Duration) time.Duration {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if len(f.expirations) == 0 {
+		return fallback
+	}
+	if wait := f.expirations[0].expiration.Sub(time.Now()); wait < fallback {
+		if wait <= 0 {
+			return time.Millisecond
+		}
+		return wait
+	}
+	return fallback
+}
+
+// sweep transitions every live item whose AddWithTTL deadline has passed
+// into a DELETE_EVENT, then runs the usual DELETE_EVENT reaper (gc),
+// returning every notification produced so the caller can publish them
+// once f.lock has been released.
+func (f *HistoricalFIFO) sweep() (notifications []Entry) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for id, item := range f.items {
+		if item.Is(DELETE_EVENT | POP_EVENT) {
+			continue
+		}
+		if e := f.expireLocked(id, item); e != nil {
+			notifications = append(notifications, e)
+		}
+	}
+	notifications = append(notifications, f.gc()...)
+	return
+}
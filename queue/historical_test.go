@@ -0,0 +1,95 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// testObj is the minimal UniqueCopyable used across this package's tests.
+type testObj struct {
+	uid string
+	val int
+}
+
+func (o *testObj) Copy() Copyable { c := *o; return &c }
+func (o *testObj) GetUID() string { return o.uid }
+
+func TestAddWithTTLExpiresViaGet(t *testing.T) {
+	f := NewFIFO(nil)
+	defer f.Close()
+
+	f.AddWithTTL("a", &testObj{"a", 1}, 20*time.Millisecond)
+	if _, exists := f.Get("a"); !exists {
+		t.Fatal("expected item to be present before its TTL deadline")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, exists := f.Get("a"); exists {
+		t.Fatal("expected Get to treat a TTL-expired item as absent")
+	}
+}
+
+func TestAddWithTTLExpiresViaList(t *testing.T) {
+	f := NewFIFO(nil)
+	defer f.Close()
+
+	f.AddWithTTL("a", &testObj{"a", 1}, 20*time.Millisecond)
+	f.Add("b", &testObj{"b", 2})
+	time.Sleep(40 * time.Millisecond)
+
+	list := f.List()
+	if len(list) != 1 || list[0].(*testObj).uid != "b" {
+		t.Fatalf("expected List to contain only the non-expired item, got %+v", list)
+	}
+}
+
+func TestAddWithTTLExpiresViaPop(t *testing.T) {
+	f := NewFIFO(nil)
+	defer f.Close()
+
+	f.AddWithTTL("a", &testObj{"a", 1}, 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	f.Add("b", &testObj{"b", 2})
+
+	v := f.Pop().(*testObj)
+	if v.uid != "b" {
+		t.Fatalf("expected Pop to skip the TTL-expired item and return \"b\", got %q", v.uid)
+	}
+}
+
+// TestAddWithTTLBackgroundSweepMarksDeleted verifies that an item's TTL is
+// enforced even when nothing ever calls Get/List/Pop for it, i.e. that
+// startExpirationSweep's background goroutine -- not just the lazy check in
+// expireLocked -- marks it deleted.
+func TestAddWithTTLBackgroundSweepMarksDeleted(t *testing.T) {
+	f := NewFIFO(nil)
+	defer f.Close()
+
+	f.AddWithTTL("a", &testObj{"a", 1}, 20*time.Millisecond)
+
+	deadline := time.Now().Add(expirationSweepInterval + time.Second)
+	for {
+		f.lock.RLock()
+		deleted := f.items["a"].Is(DELETE_EVENT)
+		f.lock.RUnlock()
+		if deleted {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the background sweeper to mark the expired item deleted without any Get/List/Pop call")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
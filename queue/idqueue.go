@@ -0,0 +1,73 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "container/list"
+
+// idQueue is the FIFO of pending IDs behind HistoricalFIFO.Pop, backed by a
+// doubly linked list plus an id->element index. Unlike a plain []string, an
+// arbitrary id can be removed in O(1) -- needed so that gc() can evict a
+// reaped id without rebuilding the whole queue, as the old implementation
+// did on every garbage collection pass.
+type idQueue struct {
+	order *list.List
+	index map[string]*list.Element
+}
+
+func newIDQueue() *idQueue {
+	return &idQueue{
+		order: list.New(),
+		index: map[string]*list.Element{},
+	}
+}
+
+func (q *idQueue) Len() int {
+	return q.order.Len()
+}
+
+// Push appends id to the back of the queue, unless it's already present.
+func (q *idQueue) Push(id string) {
+	if _, exists := q.index[id]; exists {
+		return
+	}
+	q.index[id] = q.order.PushBack(id)
+}
+
+// Pop removes and returns the id at the front of the queue, or ok=false if
+// the queue is empty.
+func (q *idQueue) Pop() (id string, ok bool) {
+	front := q.order.Front()
+	if front == nil {
+		return "", false
+	}
+	id = front.Value.(string)
+	q.order.Remove(front)
+	delete(q.index, id)
+	return id, true
+}
+
+// Remove evicts id from the queue, wherever it is, in O(1). It's a no-op if
+// id isn't queued.
+func (q *idQueue) Remove(id string) {
+	if el, exists := q.index[id]; exists {
+		q.order.Remove(el)
+		delete(q.index, id)
+	}
+}
+
+// Reset empties the queue.
+func (q *idQueue) Reset() {
+	q.order.Init()
+	q.index = map[string]*list.Element{}
+}
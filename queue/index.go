@@ -0,0 +1,177 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// evolution of: https://github.com/GoogleCloudPlatform/kubernetes/blob/release-0.6/pkg/client/cache/thread_safe_store.go
+package queue
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+)
+
+// IndexFunc computes the set of index values an object should be found
+// under for a given index.
+type IndexFunc func(obj UniqueCopyable) ([]string, error)
+
+// Indexers maps an index name to the function that computes it.
+type Indexers map[string]IndexFunc
+
+// Index maps an index value to the set of item IDs found under it.
+type Index map[string]util.StringSet
+
+// Indices maps an index name to its Index.
+type Indices map[string]Index
+
+// NamespaceIndex is the stock index name registered via NamespaceIndexFunc.
+const NamespaceIndex = "namespace"
+
+// NamespaceIndexFunc is a stock IndexFunc for the k8s-mesos scheduler,
+// indexing UniqueCopyable values by the namespace segment of their UID,
+// following the "<namespace>/<name>" UID convention. A UID without a "/"
+// is indexed under the empty-string namespace.
+func NamespaceIndexFunc(obj UniqueCopyable) ([]string, error) {
+	uid := obj.GetUID()
+	if i := strings.Index(uid, "/"); i >= 0 {
+		return []string{uid[:i]}, nil
+	}
+	return []string{""}, nil
+}
+
+// AddIndexers registers newIndexers with f. It's an error to call this once
+// f already contains items, or to register a name that already exists.
+func (f *HistoricalFIFO) AddIndexers(newIndexers Indexers) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.items) > 0 {
+		return fmt.Errorf("cannot add indexers to a non-empty store")
+	}
+	for name := range newIndexers {
+		if _, exists := f.indexers[name]; exists {
+			return fmt.Errorf("indexer %q already exists", name)
+		}
+	}
+	for name, fn := range newIndexers {
+		f.indexers[name] = fn
+	}
+	return nil
+}
+
+// Index computes index name's values for obj and returns the (non
+// DELETE_EVENT/POP_EVENT) items found under any of them.
+func (f *HistoricalFIFO) Index(name string, obj UniqueCopyable) ([]UniqueCopyable, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	indexFunc, exists := f.indexers[name]
+	if !exists {
+		return nil, fmt.Errorf("index %q does not exist", name)
+	}
+	values, err := indexFunc(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	index := f.indices[name]
+	seen := util.StringSet{}
+	result := []UniqueCopyable(nil)
+	for _, value := range values {
+		for id := range index[value] {
+			if seen.Has(id) {
+				continue
+			}
+			seen.Insert(id)
+			if item, exists := f.items[id]; exists && !item.Is(DELETE_EVENT|POP_EVENT) {
+				result = append(result, item.Value().Copy().(UniqueCopyable))
+			}
+		}
+	}
+	return result, nil
+}
+
+// ByIndex returns the (non DELETE_EVENT/POP_EVENT) items found under value
+// for the named index.
+func (f *HistoricalFIFO) ByIndex(name, value string) ([]UniqueCopyable, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if _, exists := f.indexers[name]; !exists {
+		return nil, fmt.Errorf("index %q does not exist", name)
+	}
+	set := f.indices[name][value]
+	result := make([]UniqueCopyable, 0, len(set))
+	for id := range set {
+		if item, exists := f.items[id]; exists && !item.Is(DELETE_EVENT|POP_EVENT) {
+			result = append(result, item.Value().Copy().(UniqueCopyable))
+		}
+	}
+	return result, nil
+}
+
+// IndexKeys returns the store keys (IDs) found under value for the named
+// index, skipping DELETE_EVENT/POP_EVENT entries the same way List() does.
+func (f *HistoricalFIFO) IndexKeys(name, value string) ([]string, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if _, exists := f.indexers[name]; !exists {
+		return nil, fmt.Errorf("index %q does not exist", name)
+	}
+	set := f.indices[name][value]
+	keys := make([]string, 0, len(set))
+	for id := range set {
+		if item, exists := f.items[id]; exists && !item.Is(DELETE_EVENT|POP_EVENT) {
+			keys = append(keys, id)
+		}
+	}
+	return keys, nil
+}
+
+// updateIndicesLocked updates the reverse index for id to reflect that its
+// value moved from oldObj to newObj (either may be nil: nil oldObj means id
+// is new, nil newObj means id is gone). Assumes the caller holds f.lock.
+func (f *HistoricalFIFO) updateIndicesLocked(oldObj, newObj UniqueCopyable, id string) {
+	for name, indexFunc := range f.indexers {
+		var oldValues, newValues []string
+		if oldObj != nil {
+			oldValues, _ = indexFunc(oldObj)
+		}
+		if newObj != nil {
+			newValues, _ = indexFunc(newObj)
+		}
+
+		index := f.indices[name]
+		if index == nil {
+			index = Index{}
+			f.indices[name] = index
+		}
+		for _, value := range oldValues {
+			if set := index[value]; set != nil {
+				set.Delete(id)
+				if len(set) == 0 {
+					delete(index, value)
+				}
+			}
+		}
+		for _, value := range newValues {
+			set := index[value]
+			if set == nil {
+				set = util.StringSet{}
+				index[value] = set
+			}
+			set.Insert(id)
+		}
+	}
+}